@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CacheKey identifies a file well enough to detect whether it has changed
+// since it was last hashed, without re-reading its contents.
+type CacheKey struct {
+	Path      string
+	Size      int64
+	ModTimeNs int64
+	Inode     uint64
+}
+
+// CacheEntry is a single cached digest, keyed by the file state it was
+// computed from.
+type CacheEntry struct {
+	Key  CacheKey
+	Algo string
+	Hash string
+}
+
+// Cache is a side file, stored next to a checksum file as
+// "<checksum file>.cache", that remembers the digest last computed for a
+// given (path, size, mtime, inode) so update and refresh can skip
+// rehashing files that haven't changed.
+type Cache struct {
+	path    string
+	entries map[CacheKey]CacheEntry
+	dirty   bool
+}
+
+func cachePathFor(target string) string {
+	return target + ".cache"
+}
+
+func loadCache(target string) (*Cache, error) {
+	c := &Cache{path: cachePathFor(target), entries: make(map[CacheKey]CacheEntry)}
+
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var list []CacheEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range list {
+		c.entries[entry.Key] = entry
+	}
+
+	return c, nil
+}
+
+func (c *Cache) cacheKey(path string, fi os.FileInfo) (CacheKey, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return CacheKey{}, false
+	}
+
+	return CacheKey{
+		Path:      absPath,
+		Size:      fi.Size(),
+		ModTimeNs: fi.ModTime().UnixNano(),
+		Inode:     fileInode(fi),
+	}, true
+}
+
+// Lookup returns the cached checksum for path if fi's size, mtime and
+// inode still match what was recorded.
+func (c *Cache) Lookup(path string, fi os.FileInfo) (Checksum, bool) {
+	key, ok := c.cacheKey(path, fi)
+	if !ok {
+		return Checksum{}, false
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return Checksum{}, false
+	}
+
+	return Checksum{Algo: entry.Algo, Hash: entry.Hash, Path: path}, true
+}
+
+// Store records sum as the digest for path's current file state.
+func (c *Cache) Store(path string, fi os.FileInfo, sum Checksum) {
+	key, ok := c.cacheKey(path, fi)
+	if !ok {
+		return
+	}
+
+	c.entries[key] = CacheEntry{Key: key, Algo: sum.Algo, Hash: sum.Hash}
+	c.dirty = true
+}
+
+// Save writes the cache back to disk if anything changed since it was
+// loaded.
+func (c *Cache) Save() error {
+	if !c.dirty {
+		return nil
+	}
+
+	list := make([]CacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		list = append(list, entry)
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.path, data, 0644)
+}
@@ -3,20 +3,28 @@ package main
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/urfave/cli"
 	"github.com/fatih/color"
-	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/term"
+	"lukechampine.com/blake3"
 )
 
 var (
@@ -44,6 +52,100 @@ var CommandHelpTemplate = ` Usage: dirsum [global options] {{.Name}} {{if .Flags
 
 `
 
+// -------------------------------------------------------------------
+// Hash algorithms
+
+// Supported hash algorithm names, as used by the --algo flag, checksum
+// file extensions and BSD tag lines.
+const (
+	AlgoMD5     = "md5"
+	AlgoSHA1    = "sha1"
+	AlgoSHA256  = "sha256"
+	AlgoSHA512  = "sha512"
+	AlgoBlake2b = "blake2b"
+	AlgoBlake3  = "blake3"
+)
+
+var supportedAlgos = []string{AlgoMD5, AlgoSHA1, AlgoSHA256, AlgoSHA512, AlgoBlake2b, AlgoBlake3}
+
+// extAlgos maps checksum file extensions to the algorithm they imply, as
+// written by dirsum itself and by common coreutils (sha256sum, shasum, ...).
+var extAlgos = map[string]string{
+	".md5":     AlgoMD5,
+	".sha1":    AlgoSHA1,
+	".sha256":  AlgoSHA256,
+	".sha512":  AlgoSHA512,
+	".blake2b": AlgoBlake2b,
+	".blake3":  AlgoBlake3,
+}
+
+// hexLenAlgos maps a hex digest's length to the algorithm(s) that could
+// have produced it. sha256/blake3 and sha512/blake2b-512 share a length,
+// so both appear under the same key; the first entry of each slice is
+// preferred when the file extension doesn't resolve the ambiguity.
+var hexLenAlgos = map[int][]string{
+	32:  {AlgoMD5},
+	40:  {AlgoSHA1},
+	64:  {AlgoSHA256, AlgoBlake3},
+	128: {AlgoSHA512, AlgoBlake2b},
+}
+
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case AlgoMD5:
+		return md5.New(), nil
+	case AlgoSHA1:
+		return sha1.New(), nil
+	case AlgoSHA256:
+		return sha256.New(), nil
+	case AlgoSHA512:
+		return sha512.New(), nil
+	case AlgoBlake2b:
+		return blake2b.New512(nil)
+	case AlgoBlake3:
+		return blake3.New(32, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %v (supported: %v)", algo, strings.Join(supportedAlgos, ", "))
+	}
+}
+
+func hexDigestLength(algo string) (int, bool) {
+	for n, algos := range hexLenAlgos {
+		for _, a := range algos {
+			if a == algo {
+				return n, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// detectAlgo figures out which algorithm produced a digest found in a
+// checksum file, preferring the file's extension and falling back to the
+// digest's hex length. preferred (the --algo flag) only breaks ties
+// between algorithms that share a digest length.
+func detectAlgo(path string, hexLen int, preferred string) (string, error) {
+	if algo, ok := extAlgos[filepath.Ext(path)]; ok {
+		if n, _ := hexDigestLength(algo); n == hexLen {
+			return algo, nil
+		}
+	}
+
+	candidates, ok := hexLenAlgos[hexLen]
+	if !ok {
+		return "", fmt.Errorf("unrecognized digest length: %v characters", hexLen)
+	}
+
+	for _, algo := range candidates {
+		if algo == preferred {
+			return algo, nil
+		}
+	}
+
+	return candidates[0], nil
+}
+
 // -------------------------------------------------------------------
 // Types
 
@@ -77,25 +179,34 @@ func (r *ProgressReader) Read(p []byte) (int, error) {
 }
 
 type Checksum struct {
+	Algo string
 	Hash string
 	Path string
 }
 
-func (c *Checksum) Verify() (bool, error) {
-	newHash, err := generateMd5(c.Path)
+// Verify re-hashes c.Path and reports whether it still matches c.Hash,
+// along with the hash that was actually computed (useful for reporting a
+// mismatch's actual value even though Verify itself only returns a bool).
+func (c *Checksum) Verify() (bool, string, error) {
+	newHash, err := generateHash(c.Path, c.Algo)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
-	if c.Hash == newHash {
-		return true, nil
-	}
-
-	return false, nil
+	return c.Hash == newHash, newHash, nil
 }
 
+// String renders c in the GNU "<hex> *<path>" form for md5 (kept
+// unadorned for compatibility with plain md5sum output), or the BSD
+// "ALGO (path) = <hex>" tag form for every other algorithm, since
+// sha256/blake3 and sha512/blake2b share a digest length and can't be
+// told apart by length alone when read back.
 func (c *Checksum) String() string {
-	return fmt.Sprintf("%v *%v", c.Hash, c.Path)
+	if c.Algo == AlgoMD5 {
+		return fmt.Sprintf("%v *%v", c.Hash, c.Path)
+	}
+
+	return fmt.Sprintf("%v (%v) = %v", c.Algo, c.Path, c.Hash)
 }
 
 type Checksums []Checksum
@@ -122,7 +233,21 @@ func (slice Checksums) Swap(a, b int) {
 // -------------------------------------------------------------------
 // Functions
 
-func readFile(path string) (Checksums, error) {
+// gnuLineExp matches both GNU coreutils checksum line modes: "*" marks a
+// binary-mode checksum, a plain space marks text mode (the default for
+// e.g. "sha256sum" and "shasum -a 256" with no arguments).
+var gnuLineExp = regexp.MustCompile(`^([0-9a-fA-F]{8,}) ([ *])(.*)$`)
+var bsdLineExp = regexp.MustCompile(`^([A-Za-z0-9]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// readFile parses a checksum file in either the GNU coreutils
+// "<hex> *<path>"/"<hex>  <path>" format or the BSD "ALGO (path) = <hex>"
+// tag format, auto-detecting the hash algorithm used by each line.
+// preferredAlgo (the --algo flag) is only consulted to break ties between
+// algorithms that share a digest length. Unless allowMixed is set, a file
+// whose lines resolve to more than one algorithm is rejected. A file that
+// yields no recognized checksum lines at all is rejected too, rather than
+// silently reporting a vacuous verify success.
+func readFile(path string, preferredAlgo string, allowMixed bool) (Checksums, error) {
 	var sums Checksums
 
 	data, err := ioutil.ReadFile(path)
@@ -130,8 +255,9 @@ func readFile(path string) (Checksums, error) {
 		return sums, err
 	}
 
-	r := regexp.MustCompile(`^[0-9a-fA-F]{32} \*.*$`)
 	dir := filepath.Dir(path)
+	seenAlgos := make(map[string]bool)
+	nonBlankLines := 0
 
 	// Replace CRLF line endings with LF and split on each line.
 	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
@@ -139,56 +265,57 @@ func readFile(path string) (Checksums, error) {
 		if len(line) <= 0 {
 			continue
 		}
+		nonBlankLines++
+
+		var algo, hexHash, filePath string
 
-		// Only process valid lines and ignore everything else.
-		if r.MatchString(line) {
-			split := strings.Split(line, " *")
-			// Append the file path to relative checksums to make sure they are
-			// relative to the current working directory.
-			if !filepath.IsAbs(split[1]) {
-				split[1] = filepath.Join(dir, split[1])
+		if m := bsdLineExp.FindStringSubmatch(line); m != nil {
+			algo, filePath, hexHash = strings.ToLower(m[1]), m[2], strings.ToLower(m[3])
+			if _, ok := hexDigestLength(algo); !ok {
+				// Not a hash algorithm we recognize; ignore the line.
+				continue
 			}
+		} else if m := gnuLineExp.FindStringSubmatch(line); m != nil {
+			hexHash, filePath = strings.ToLower(m[1]), m[3]
+			algo, err = detectAlgo(path, len(hexHash), preferredAlgo)
+			if err != nil {
+				continue
+			}
+		} else {
+			// Ignore anything that isn't a recognized checksum line.
+			continue
+		}
+
+		seenAlgos[algo] = true
+		if len(seenAlgos) > 1 && !allowMixed {
+			return nil, fmt.Errorf("%v: mixes multiple hash algorithms; pass --allow-mixed to read it anyway", path)
+		}
 
-			sums = append(sums, Checksum{
-				Hash: split[0],
-				Path: split[1],
-			})
+		// Append the file path to relative checksums to make sure they are
+		// relative to the current working directory.
+		if !filepath.IsAbs(filePath) {
+			filePath = filepath.Join(dir, filePath)
 		}
+
+		sums = append(sums, Checksum{Algo: algo, Hash: hexHash, Path: filePath})
+	}
+
+	if len(sums) == 0 && nonBlankLines > 0 {
+		return nil, fmt.Errorf("%v: no recognized checksum lines found", path)
 	}
 
 	return sums, nil
 }
 
-func readFileToMap(path string) (map[string]Checksum, error) {
-	data, err := ioutil.ReadFile(path)
+func readFileToMap(path string, preferredAlgo string, allowMixed bool) (map[string]Checksum, error) {
+	sums, err := readFile(path, preferredAlgo, allowMixed)
 	if err != nil {
 		return nil, err
 	}
 
-	r := regexp.MustCompile(`^[0-9a-fA-F]{32} \*.*$`)
-
-	dir := filepath.Dir(path)
-	var checksumMap map[string]Checksum
-	checksumMap = make(map[string]Checksum)
-
-	// Replace CRLF line endings with LF and split on each line.
-	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
-	for _, line := range lines {
-		if len(line) <= 0 {
-			continue
-		}
-
-		// Only process valid lines and ignore everything else.
-		if r.MatchString(line) {
-			split := strings.Split(line, " *")
-			// Append the file path to relative checksums to make sure they are
-			// relative to the current working directory.
-			if !filepath.IsAbs(split[1]) {
-				split[1] = filepath.Join(dir, split[1])
-			}
-
-			checksumMap[split[1]] = Checksum{split[0], split[1]}
-		}
+	checksumMap := make(map[string]Checksum, len(sums))
+	for _, sum := range sums {
+		checksumMap[sum.Path] = sum
 	}
 
 	return checksumMap, nil
@@ -210,20 +337,26 @@ func writeFile(sums Checksums, path string) error {
 			}
 		}
 
-		output.WriteString(fmt.Sprintf("%v *%v\n", sum.Hash, relPath))
+		relSum := sum
+		relSum.Path = relPath
+		output.WriteString(relSum.String() + "\n")
 	}
 
 	return ioutil.WriteFile(path, output.Bytes(), 0644)
 }
 
-func generateMd5(path string) (string, error) {
+func generateHash(path string, algo string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hash := md5.New()
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+
 	if IsTerminal {
 		fileInfo, err := file.Stat()
 		if err != nil {
@@ -238,46 +371,219 @@ func generateMd5(path string) (string, error) {
 		fmt.Printf(" >>  %s\r", ellipsize(filepath.Base(path), TermWidth-6))
 		defer fmt.Printf("%s\r", strings.Repeat(" ", TermWidth-1))
 
-		if _, err := io.Copy(hash, pReader); err != nil {
+		if _, err := io.Copy(hasher, pReader); err != nil {
 			return "", err
 		}
 	} else {
-		if _, err := io.Copy(hash, file); err != nil {
+		if _, err := io.Copy(hasher, file); err != nil {
 			return "", err
 		}
 	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
-func verifyFile(path string) {
-	sums, err := readFile(path)
+// hashFile hashes path with algo, without drawing any per-file progress,
+// so it can be called concurrently from a worker pool. It also returns
+// the number of bytes read, for aggregate throughput reporting.
+func hashFile(path string, algo string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(hasher, file)
 	if err != nil {
-		printColored(fmt.Sprintf(" Error: %v\n", err), color.FgRed)
+		return "", 0, err
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), size, nil
+}
+
+// hashProgress aggregates progress across the worker pool and draws a
+// single "files hashed / total (bytes/sec)" status line, throttled the
+// same way ProgressReader throttles its per-file percentage. All state is
+// guarded by mu so workers can report concurrently.
+type hashProgress struct {
+	mu         sync.Mutex
+	filesDone  int
+	totalFiles int
+	bytesDone  int64
+	startTime  time.Time
+	lastDraw   time.Time
+}
+
+func newHashProgress(totalFiles int) *hashProgress {
+	return &hashProgress{totalFiles: totalFiles, startTime: time.Now()}
+}
+
+func (p *hashProgress) Add(bytesRead int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.filesDone++
+	p.bytesDone += bytesRead
+
+	if !IsTerminal {
 		return
 	}
 
-	hasErrors := false
-	for _, c := range sums {
-		isValid, err := c.Verify()
+	if p.filesDone == p.totalFiles || p.lastDraw.Add(time.Millisecond*150).Before(time.Now()) {
+		elapsed := time.Since(p.startTime).Seconds()
+		var rate float64
+		if elapsed > 0 {
+			rate = float64(p.bytesDone) / elapsed
+		}
+
+		fmt.Printf(" >> %d/%d files (%s/s)\r", p.filesDone, p.totalFiles, humanBytes(int64(rate)))
+		p.lastDraw = time.Now()
+	}
+}
+
+func (p *hashProgress) Done() {
+	if IsTerminal {
+		fmt.Printf("%s\r", strings.Repeat(" ", TermWidth-1))
+	}
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for n/div >= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-		if !isValid {
-			if !hasErrors {
-				hasErrors = true
-				printColored(fmt.Sprintf(" ER: %v\n", path), color.FgRed)
+// hashFilesConcurrently hashes paths using jobs worker goroutines fed by a
+// single channel, reporting aggregate progress as they complete. Results
+// are sorted before being returned so output stays deterministic
+// regardless of which worker finishes a given file first.
+func hashFilesConcurrently(paths []string, algo string, jobs int) (Checksums, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	pathChan := make(chan string)
+	sumChan := make(chan Checksum, len(paths))
+	errChan := make(chan error, len(paths))
+	progress := newHashProgress(len(paths))
+
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range pathChan {
+				hash, size, err := hashFile(path, algo)
+				if err != nil {
+					errChan <- err
+					continue
+				}
+
+				progress.Add(size)
+				sumChan <- Checksum{Algo: algo, Hash: hash, Path: path}
 			}
+		}()
+	}
 
-			if err != nil {
-				printColored(fmt.Sprintf("   Error: %v\n", c.Path), color.FgRed)
+	go func() {
+		for _, path := range paths {
+			pathChan <- path
+		}
+		close(pathChan)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(sumChan)
+		close(errChan)
+	}()
+
+	sums := make(Checksums, 0, len(paths))
+	for sum := range sumChan {
+		sums = append(sums, sum)
+	}
+	progress.Done()
+
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+
+	sort.Sort(sums)
+	return sums, nil
+}
+
+// verifyFile checks every checksum recorded in path and reports the
+// result of each through reporter, returning the counts so verifyCommand
+// can aggregate them across files and decide on an exit code.
+func verifyFile(path string, preferredAlgo string, allowMixed bool, reporter Reporter) Summary {
+	var summary Summary
+
+	reporter.StartFile(path)
+
+	sums, err := readFile(path, preferredAlgo, allowMixed)
+	if err != nil {
+		summary.Total++
+		summary.Errors++
+		reporter.ReportEntry(FileReport{Path: path, Status: StatusError, Error: err.Error()})
+		reporter.EndFile(path, false)
+		return summary
+	}
+
+	ok := true
+	for _, c := range sums {
+		start := time.Now()
+		valid, actual, verifyErr := c.Verify()
+		entry := FileReport{
+			Path:       c.Path,
+			Expected:   c.Hash,
+			Actual:     actual,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if fi, statErr := os.Stat(c.Path); statErr == nil {
+			entry.Bytes = fi.Size()
+		}
+
+		switch {
+		case verifyErr != nil:
+			ok = false
+			entry.Error = verifyErr.Error()
+			if os.IsNotExist(verifyErr) {
+				entry.Status = StatusMissing
+				summary.Missing++
 			} else {
-				printColored(fmt.Sprintf("   Invalid: %v\n", c.Path), color.FgRed)
+				entry.Status = StatusError
+				summary.Errors++
 			}
+		case !valid:
+			ok = false
+			entry.Status = StatusMismatch
+			summary.Mismatched++
+		default:
+			entry.Status = StatusOK
+			summary.OK++
 		}
-	}
 
-	if !hasErrors {
-		printColored(fmt.Sprintf(" OK: %v\n", path), color.FgGreen)
+		summary.Total++
+		reporter.ReportEntry(entry)
 	}
+
+	reporter.EndFile(path, ok)
+	return summary
 }
 
 // -------------------------------------------------------------------
@@ -329,45 +635,19 @@ func newCommand(ctx *cli.Context) error {
 	}
 
 	source, output := ctx.Args()[0], ctx.Args()[1]
-	outputBase := filepath.Base(output)
+	algo := ctx.GlobalString("algo")
+	jobs := ctx.Int("jobs")
 	fmt.Print(sprintfHeader("Hashing: %v", source))
 
-	var sums Checksums
-	err := filepath.Walk(source, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if fi.IsDir() {
-			return nil
-		}
-
-		// Exclude the output file from the sourceMap.
-		if strings.HasSuffix(path, outputBase) {
-			pathAbs, err := filepath.Abs(path)
-			if err != nil {
-				return err
-			}
-
-			outputAbs, err := filepath.Abs(output)
-			if err != nil {
-				return err
-			}
-
-			if pathAbs == outputAbs {
-				return nil
-			}
-		}
-
-		hash, err := generateMd5(path)
-		if err != nil {
-			return err
-		}
+	opts, err := loadFilterOpts(ctx, source, output)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
 
-		sums = append(sums, Checksum{hash, path})
-		return nil
-	})
+	paths := collectFiles(source, opts)
 
+	sums, err := hashFilesConcurrently(paths, algo, jobs)
 	if err != nil {
 		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
 		os.Exit(1)
@@ -391,49 +671,27 @@ func updateCommand(ctx *cli.Context) error {
 	}
 
 	source, target := ctx.Args()[0], ctx.Args()[1]
-	targetBase := filepath.Base(target)
+	algo := ctx.GlobalString("algo")
+	allowMixed := ctx.GlobalBool("allow-mixed")
+	jobs := ctx.Int("jobs")
 	fmt.Print(sprintfHeader("Updating: %v", target))
 
 	// Get new files from the source path.
-	var sourceMap map[string]bool
-	sourceMap = make(map[string]bool)
-	err := filepath.Walk(source, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if fi.IsDir() {
-			return nil
-		}
-
-		// Exclude the output file from the sourceMap.
-		if strings.HasSuffix(path, targetBase) {
-			pathAbs, err := filepath.Abs(path)
-			if err != nil {
-				return err
-			}
-
-			targetAbs, err := filepath.Abs(target)
-			if err != nil {
-				return err
-			}
-
-			if pathAbs == targetAbs {
-				return nil
-			}
-		}
-
-		sourceMap[path] = true
-		return nil
-	})
-
+	opts, err := loadFilterOpts(ctx, source, target)
 	if err != nil {
 		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
 		os.Exit(1)
 	}
 
+	sourcePaths := collectFiles(source, opts)
+
+	sourceMap := make(map[string]bool, len(sourcePaths))
+	for _, path := range sourcePaths {
+		sourceMap[path] = true
+	}
+
 	// Get current checksums from the target file.
-	targetMap, err := readFileToMap(target)
+	targetMap, err := readFileToMap(target, algo, allowMixed)
 	if err != nil {
 		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
 		os.Exit(1)
@@ -449,19 +707,49 @@ func updateCommand(ctx *cli.Context) error {
 
 	}
 
-	// Insert new checksum in the target map.
-	for key, _ := range sourceMap {
-		if _, ok := targetMap[key]; !ok {
-			hash, err := generateMd5(key)
-			if err != nil {
-				printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
-				os.Exit(1)
+	// Hash files present in the source but missing from the target map,
+	// skipping any whose content cache still matches their current stat.
+	cache, err := loadCache(target)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	var newPaths []string
+	for key := range sourceMap {
+		if _, ok := targetMap[key]; ok {
+			continue
+		}
+
+		if fi, err := os.Stat(key); err == nil {
+			if sum, ok := cache.Lookup(key, fi); ok && sum.Algo == algo {
+				targetMap[key] = sum
+				continue
 			}
+		}
+
+		newPaths = append(newPaths, key)
+	}
+
+	newSums, err := hashFilesConcurrently(newPaths, algo, jobs)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
 
-			targetMap[key] = Checksum{hash, key}
+	for _, sum := range newSums {
+		targetMap[sum.Path] = sum
+
+		if fi, err := os.Stat(sum.Path); err == nil {
+			cache.Store(sum.Path, fi, sum)
 		}
 	}
 
+	if err := cache.Save(); err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
 	var targetSlice Checksums
 	targetSlice = make(Checksums, 0, len(targetMap))
 	for _, sum := range targetMap {
@@ -478,6 +766,117 @@ func updateCommand(ctx *cli.Context) error {
 	return nil
 }
 
+// refreshCommand restats every file recorded in target and re-hashes only
+// those whose size or mtime no longer match their cache entry, so a
+// caller can detect silent content changes without paying the cost of a
+// full rehash of the tree.
+func refreshCommand(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		fmt.Println("Incorrect Usage.\n")
+		cli.ShowSubcommandHelp(ctx)
+		return nil
+	}
+
+	target := ctx.Args()[0]
+	allowMixed := ctx.GlobalBool("allow-mixed")
+	jobs := ctx.Int("jobs")
+	fmt.Print(sprintfHeader("Refreshing: %v", target))
+
+	targetMap, err := readFileToMap(target, ctx.GlobalString("algo"), allowMixed)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	cache, err := loadCache(target)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	// Group files needing a rehash by algorithm, since a --allow-mixed
+	// target can record more than one.
+	staleByAlgo := make(map[string][]string)
+	for path, sum := range targetMap {
+		fi, err := os.Stat(path)
+		if err != nil {
+			printColored(fmt.Sprintf(" Missing: %v\n", path), color.FgRed)
+			continue
+		}
+
+		if cached, ok := cache.Lookup(path, fi); ok && cached.Algo == sum.Algo && cached.Hash == sum.Hash {
+			continue
+		}
+
+		staleByAlgo[sum.Algo] = append(staleByAlgo[sum.Algo], path)
+	}
+
+	changed := 0
+	for algo, paths := range staleByAlgo {
+		sums, err := hashFilesConcurrently(paths, algo, jobs)
+		if err != nil {
+			printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+			os.Exit(1)
+		}
+
+		for _, sum := range sums {
+			if old, ok := targetMap[sum.Path]; !ok || old.Hash != sum.Hash {
+				changed++
+				printColored(fmt.Sprintf(" Changed: %v\n", sum.Path), color.FgYellow)
+			}
+
+			targetMap[sum.Path] = sum
+
+			if fi, err := os.Stat(sum.Path); err == nil {
+				cache.Store(sum.Path, fi, sum)
+			}
+		}
+	}
+
+	if err := cache.Save(); err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	targetSlice := make(Checksums, 0, len(targetMap))
+	for _, sum := range targetMap {
+		targetSlice = append(targetSlice, sum)
+	}
+
+	if err := writeFile(targetSlice, target); err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	if changed == 0 {
+		printColored(fmt.Sprintf(" Unchanged: %v\n\n", target), color.FgGreen)
+	} else {
+		printColored(fmt.Sprintf(" Refreshed: %v (%d changed)\n\n", target, changed), color.FgGreen)
+	}
+
+	return nil
+}
+
+// reportVerifyFatal reports a failure that stops verifyCommand before it
+// can check any checksum file at all (the path doesn't exist, the
+// filters can't be loaded, ...) through reporter instead of printing
+// directly, so --output=json/ndjson still gets a well-formed record
+// rather than interleaved plain text. It never returns.
+func reportVerifyFatal(reporter Reporter, textOutput bool, start time.Time, path string, err error) {
+	reporter.StartFile(path)
+	reporter.ReportEntry(FileReport{Path: path, Status: StatusError, Error: err.Error()})
+	reporter.EndFile(path, false)
+
+	summary := Summary{Total: 1, Errors: 1, ElapsedMs: time.Since(start).Milliseconds()}
+	reporter.Summary(summary)
+
+	if textOutput {
+		fmt.Print("\n")
+	}
+
+	os.Exit(1)
+}
+
 func verifyCommand(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		fmt.Println("Incorrect Usage.\n")
@@ -486,32 +885,55 @@ func verifyCommand(ctx *cli.Context) error {
 	}
 
 	path := ctx.Args()[0]
-	fmt.Print(sprintfHeader("Veryfing: %v", path))
+	algo := ctx.GlobalString("algo")
+	allowMixed := ctx.GlobalBool("allow-mixed")
 
-	fileInfo, err := os.Stat(path)
+	reporter, closeReporter, err := newReporter(ctx)
 	if err != nil {
 		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
 		os.Exit(1)
 	}
+	defer closeReporter()
+
+	textOutput := ctx.GlobalString("output") == "" || ctx.GlobalString("output") == "text"
+	if textOutput {
+		fmt.Print(sprintfHeader("Veryfing: %v", path))
+	}
+
+	start := time.Now()
+	var total Summary
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		reportVerifyFatal(reporter, textOutput, start, path, err)
+	}
 
 	if fileInfo.IsDir() {
-		filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
-			if err != nil {
-				printColored(fmt.Sprintf(" Error: %v\n", err), color.FgRed)
-				return nil
-			}
+		opts, err := loadFilterOpts(ctx, path, "")
+		if err != nil {
+			reportVerifyFatal(reporter, textOutput, start, path, err)
+		}
 
-			if strings.HasSuffix(path, ".md5") && !fi.IsDir() {
-				verifyFile(path)
+		for candidate := range walkFiltered(path, opts) {
+			if _, ok := extAlgos[filepath.Ext(candidate)]; ok {
+				total.add(verifyFile(candidate, algo, allowMixed, reporter))
 			}
-
-			return nil
-		})
+		}
 	} else {
-		verifyFile(path)
+		total.add(verifyFile(path, algo, allowMixed, reporter))
+	}
+
+	total.ElapsedMs = time.Since(start).Milliseconds()
+	reporter.Summary(total)
+
+	if textOutput {
+		fmt.Print("\n")
+	}
+
+	if total.Mismatched+total.Missing+total.Errors > 0 {
+		os.Exit(1)
 	}
 
-	fmt.Print("\n")
 	return nil
 }
 
@@ -535,28 +957,78 @@ func main() {
 			Usage:       "disable colored output",
 			Destination: &color.NoColor,
 		},
+		cli.StringFlag{
+			Name:  "algo, a",
+			Usage: fmt.Sprintf("hash algorithm to use (%v)", strings.Join(supportedAlgos, ", ")),
+			Value: AlgoMD5,
+		},
+		cli.BoolFlag{
+			Name:  "allow-mixed",
+			Usage: "allow reading checksum files that mix multiple hash algorithms",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "report format: text, json, or ndjson",
+			Value: "text",
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "write the report to this file instead of stdout",
+		},
 	}
 
 	// Initialize global variables before executing commands.
 	app.Before = func(ctx *cli.Context) error {
 		// Check if stdout is a terminal and attempt to get the terminal width.
 		fd := int(os.Stdout.Fd())
-		if terminal.IsTerminal(fd) {
-			if width, _, err := terminal.GetSize(fd); err == nil {
+		if term.IsTerminal(fd) {
+			if width, _, err := term.GetSize(fd); err == nil {
 				TermWidth = width
 				IsTerminal = true
 			}
 		}
 
+		if _, err := newHasher(ctx.GlobalString("algo")); err != nil {
+			return err
+		}
+
 		return nil
 	}
 
+	jobsFlag := cli.IntFlag{
+		Name:  "jobs, j",
+		Usage: "number of files to hash concurrently",
+		Value: runtime.NumCPU(),
+	}
+
+	symlinksFlag := cli.StringFlag{
+		Name:  "symlinks",
+		Usage: "how to treat symlinks: ignore, follow, or hash-target",
+		Value: "ignore",
+	}
+
+	includeFlag := cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "gitignore-style pattern to re-include; overrides earlier --exclude rules",
+	}
+
+	excludeFlag := cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "gitignore-style pattern to exclude from the walk",
+	}
+
+	excludeFromFlag := cli.StringFlag{
+		Name:  "exclude-from",
+		Usage: "read gitignore-style exclude patterns from file",
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:        "new",
 			Aliases:     []string{"n"},
 			Usage:       "create md5 file",
 			Description: "source output\n\n Arguments:\n   source \tdirectory to checksum\n   output \toutput file",
+			Flags:       []cli.Flag{jobsFlag, includeFlag, excludeFlag, excludeFromFlag},
 			Action:      newCommand,
 		},
 		{
@@ -569,9 +1041,21 @@ func main() {
 					Name:  "delete, d",
 					Usage: "also remove missing checksums from target",
 				},
+				jobsFlag,
+				includeFlag,
+				excludeFlag,
+				excludeFromFlag,
 			},
 			Action: updateCommand,
 		},
+		{
+			Name:        "refresh",
+			Aliases:     []string{"r"},
+			Usage:       "restat checksummed files and re-hash any that changed",
+			Description: "target\n\n Arguments:\n   target \tmd5 file to refresh",
+			Flags:       []cli.Flag{jobsFlag},
+			Action:      refreshCommand,
+		},
 		{
 			Name:        "verify",
 			Aliases:     []string{"v"},
@@ -582,9 +1066,32 @@ func main() {
 					Name:  "basic, b",
 					Usage: "only check if the files exists",
 				},
+				includeFlag,
+				excludeFlag,
+				excludeFromFlag,
 			},
 			Action: verifyCommand,
 		},
+		{
+			Name:        "treehash",
+			Usage:       "compute a single Merkle-style digest for a directory tree",
+			Description: "root\n\n Arguments:\n   root \tdirectory to hash",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "manifest, m",
+					Usage: "also print the digest of every file and directory",
+				},
+				symlinksFlag,
+			},
+			Action: treehashCommand,
+		},
+		{
+			Name:        "treeverify",
+			Usage:       "recompute a tree digest and compare it against a reference",
+			Description: "root reference\n\n Arguments:\n   root      \tdirectory to verify\n   reference \tdigest, or a treehash --manifest output file to diff against",
+			Flags:       []cli.Flag{symlinksFlag},
+			Action:      treeverifyCommand,
+		},
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectAlgo(t *testing.T) {
+	cases := []struct {
+		name      string
+		path      string
+		hexLen    int
+		preferred string
+		want      string
+		wantErr   bool
+	}{
+		{"unambiguous length", "out.md5", 32, AlgoSHA256, AlgoMD5, false},
+		{"extension resolves a shared length", "out.blake3", 64, AlgoMD5, AlgoBlake3, false},
+		{"preferred algo breaks a tie", "out.txt", 64, AlgoBlake3, AlgoBlake3, false},
+		{"first candidate when nothing else resolves the tie", "out.txt", 64, AlgoMD5, AlgoSHA256, false},
+		{"unrecognized digest length", "out.txt", 7, AlgoMD5, "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := detectAlgo(c.path, c.hexLen, c.preferred)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("detectAlgo(%q, %d, %q) = %q, nil; want an error", c.path, c.hexLen, c.preferred, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("detectAlgo(%q, %d, %q): unexpected error: %v", c.path, c.hexLen, c.preferred, err)
+			}
+
+			if got != c.want {
+				t.Fatalf("detectAlgo(%q, %d, %q) = %q, want %q", c.path, c.hexLen, c.preferred, got, c.want)
+			}
+		})
+	}
+}
+
+// TestWriteFileRoundTripNonMD5 guards against regressing to
+// filename-extension-only algorithm detection on read: a checksum
+// written with an algorithm that shares a digest length with another
+// (sha256/blake3, sha512/blake2b) must read back as the algorithm it was
+// actually written with, even when --algo isn't repeated on the read (the
+// common case, since --algo defaults to md5). Before this was fixed,
+// `dirsum --algo blake3 new` followed by a plain `dirsum refresh` or
+// `dirsum verify` silently reread the digests as sha256 and, for
+// refresh, rewrote the file under the wrong algorithm.
+func TestWriteFileRoundTripNonMD5(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(srcFile, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []string{AlgoSHA256, AlgoBlake3, AlgoSHA512, AlgoBlake2b} {
+		t.Run(algo, func(t *testing.T) {
+			hash, err := generateHash(srcFile, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := filepath.Join(dir, "out.md5")
+			if err := writeFile(Checksums{{Algo: algo, Hash: hash, Path: srcFile}}, out); err != nil {
+				t.Fatal(err)
+			}
+
+			// Read back with a different preferred algo, as refresh/verify do
+			// whenever --algo isn't repeated.
+			sums, err := readFile(out, AlgoMD5, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sums) != 1 {
+				t.Fatalf("got %d checksums, want 1", len(sums))
+			}
+
+			if sums[0].Algo != algo {
+				t.Fatalf("got algo %q, want %q (written line: %q)", sums[0].Algo, algo, (&Checksum{Algo: algo, Hash: hash, Path: srcFile}).String())
+			}
+
+			if sums[0].Hash != hash {
+				t.Fatalf("got hash %q, want %q", sums[0].Hash, hash)
+			}
+		})
+	}
+}
+
+func TestReadFileRejectsUnparseableButAllowsEmpty(t *testing.T) {
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.md5")
+	if err := os.WriteFile(garbage, []byte("this is not a checksum line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readFile(garbage, AlgoMD5, false); err == nil {
+		t.Fatal("readFile on a file with no recognized checksum lines should fail, not report a vacuous success")
+	}
+
+	empty := filepath.Join(dir, "empty.md5")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sums, err := readFile(empty, AlgoMD5, false)
+	if err != nil {
+		t.Fatalf("readFile on a genuinely empty checksum file should succeed, got: %v", err)
+	}
+
+	if len(sums) != 0 {
+		t.Fatalf("got %d checksums from an empty file, want 0", len(sums))
+	}
+}
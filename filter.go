@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli"
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// dirsumIgnoreFile is read automatically from a source root, the same way
+// git reads .gitignore.
+const dirsumIgnoreFile = ".dirsumignore"
+
+// FilterOpts controls what walkFiltered yields: ExcludeFile is skipped
+// outright (typically the checksum file a command is about to write),
+// and Matcher, if set, is used to skip anything it matches the way a
+// .gitignore would.
+type FilterOpts struct {
+	ExcludeFile string
+	Matcher     *ignore.GitIgnore
+}
+
+// loadIgnoreMatcher builds the gitignore-style matcher for a walk over
+// source, combining (in increasing precedence) source's own
+// .dirsumignore, --exclude-from's file, --exclude patterns, and finally
+// --include patterns, which are compiled as negations so they can
+// re-include anything excluded by an earlier rule.
+func loadIgnoreMatcher(source string, excludeFrom string, excludes []string, includes []string) (*ignore.GitIgnore, error) {
+	var lines []string
+
+	if data, err := ioutil.ReadFile(filepath.Join(source, dirsumIgnoreFile)); err == nil {
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if excludeFrom != "" {
+		data, err := ioutil.ReadFile(excludeFrom)
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	lines = append(lines, excludes...)
+
+	for _, pattern := range includes {
+		if !strings.HasPrefix(pattern, "!") {
+			pattern = "!" + pattern
+		}
+
+		lines = append(lines, pattern)
+	}
+
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	return ignore.CompileIgnoreLines(lines...), nil
+}
+
+// loadFilterOpts reads the --include/--exclude/--exclude-from flags off
+// ctx and builds the FilterOpts for a walk rooted at source.
+func loadFilterOpts(ctx *cli.Context, source string, excludeFile string) (FilterOpts, error) {
+	matcher, err := loadIgnoreMatcher(source, ctx.String("exclude-from"), ctx.StringSlice("exclude"), ctx.StringSlice("include"))
+	if err != nil {
+		return FilterOpts{}, err
+	}
+
+	return FilterOpts{ExcludeFile: excludeFile, Matcher: matcher}, nil
+}
+
+// walkFiltered walks root and streams every regular file that survives
+// opts.Matcher onto the returned channel, skipping opts.ExcludeFile and
+// pruning whole directories opts.Matcher excludes. Walk errors are
+// printed and skipped rather than aborting the walk, matching how the
+// rest of dirsum treats per-file errors during a tree walk.
+func walkFiltered(root string, opts FilterOpts) <-chan string {
+	out := make(chan string)
+
+	var excludeAbs string
+	if opts.ExcludeFile != "" {
+		excludeAbs, _ = filepath.Abs(opts.ExcludeFile)
+	}
+
+	go func() {
+		defer close(out)
+
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				printColored(fmt.Sprintf(" Error: %v\n", err), color.FgRed)
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				rel = path
+			}
+			rel = filepath.ToSlash(rel)
+
+			if fi.IsDir() {
+				if rel != "." && opts.Matcher != nil && opts.Matcher.MatchesPath(rel+"/") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if excludeAbs != "" {
+				if pathAbs, err := filepath.Abs(path); err == nil && pathAbs == excludeAbs {
+					return nil
+				}
+			}
+
+			if opts.Matcher != nil && opts.Matcher.MatchesPath(rel) {
+				return nil
+			}
+
+			out <- path
+			return nil
+		})
+	}()
+
+	return out
+}
+
+// collectFiles drains walkFiltered into a slice, for callers that need
+// the full file list up front (to know a total count, for example).
+func collectFiles(root string, opts FilterOpts) []string {
+	var paths []string
+	for path := range walkFiltered(root, opts) {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
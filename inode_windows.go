@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// os.FileInfo doesn't expose an inode-like identifier on Windows; size
+// and mtime are enough of a signal there, so this always returns 0.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli"
+)
+
+// ReportStatus is the outcome of checking a single checksum entry.
+type ReportStatus string
+
+const (
+	StatusOK       ReportStatus = "ok"
+	StatusMismatch ReportStatus = "mismatch"
+	StatusMissing  ReportStatus = "missing"
+	StatusError    ReportStatus = "error"
+)
+
+// FileReport is one checked checksum entry, in the shape emitted by
+// --output=json/ndjson.
+type FileReport struct {
+	Path       string       `json:"path"`
+	Expected   string       `json:"expected"`
+	Actual     string       `json:"actual,omitempty"`
+	Status     ReportStatus `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	Bytes      int64        `json:"bytes"`
+	DurationMs int64        `json:"duration_ms"`
+}
+
+// Summary totals a run of FileReports. It's also used to accumulate
+// results across several checksum files in a single verify run, so add
+// merges counts without touching ElapsedMs, which the caller sets once
+// for the whole run.
+type Summary struct {
+	Total      int   `json:"total"`
+	OK         int   `json:"ok"`
+	Mismatched int   `json:"mismatched"`
+	Missing    int   `json:"missing"`
+	Errors     int   `json:"errors"`
+	ElapsedMs  int64 `json:"elapsed_ms"`
+}
+
+func (s *Summary) add(other Summary) {
+	s.Total += other.Total
+	s.OK += other.OK
+	s.Mismatched += other.Mismatched
+	s.Missing += other.Missing
+	s.Errors += other.Errors
+}
+
+// Reporter receives the results of a verify run so that printing can live
+// in one place rather than being scattered through verifyFile. StartFile
+// and EndFile bracket one checksum file's worth of ReportEntry calls;
+// Summary is called once, after every file has been checked.
+type Reporter interface {
+	StartFile(path string)
+	ReportEntry(entry FileReport)
+	EndFile(path string, ok bool)
+	Summary(summary Summary)
+}
+
+// TextReporter reproduces dirsum's original verify output: a lazily
+// printed " ER: <file>" banner on the first failing entry, an "Invalid"
+// or "Error" line per failure, and an " OK: <file>" line for a clean
+// file. If w is set, the same plain (uncolored) text is duplicated there,
+// for pairing --report with --output=text.
+type TextReporter struct {
+	w         io.Writer
+	curPath   string
+	hasErrors bool
+	printed   bool
+}
+
+func (r *TextReporter) emit(s string, clr color.Attribute) {
+	printColored(s, clr)
+	if r.w != nil {
+		fmt.Fprint(r.w, s)
+	}
+}
+
+func (r *TextReporter) StartFile(path string) {
+	r.curPath = path
+	r.hasErrors = false
+	r.printed = false
+}
+
+func (r *TextReporter) ReportEntry(entry FileReport) {
+	if entry.Status == StatusOK {
+		return
+	}
+
+	r.hasErrors = true
+	if !r.printed {
+		r.emit(fmt.Sprintf(" ER: %v\n", r.curPath), color.FgRed)
+		r.printed = true
+	}
+
+	if entry.Status == StatusMismatch {
+		r.emit(fmt.Sprintf("   Invalid: %v\n", entry.Path), color.FgRed)
+	} else {
+		r.emit(fmt.Sprintf("   Error: %v\n", entry.Path), color.FgRed)
+	}
+}
+
+func (r *TextReporter) EndFile(path string, ok bool) {
+	if !r.hasErrors {
+		r.emit(fmt.Sprintf(" OK: %v\n", path), color.FgGreen)
+	}
+}
+
+func (r *TextReporter) Summary(summary Summary) {}
+
+// JSONReporter emits one JSON record per checked entry plus a final
+// summary record, either as newline-delimited JSON (ndjson) or as a
+// single {files, summary} document.
+type JSONReporter struct {
+	w       io.Writer
+	ndjson  bool
+	entries []FileReport
+}
+
+func (r *JSONReporter) StartFile(path string) {}
+
+func (r *JSONReporter) ReportEntry(entry FileReport) {
+	if r.ndjson {
+		r.writeLine(entry)
+		return
+	}
+
+	r.entries = append(r.entries, entry)
+}
+
+func (r *JSONReporter) EndFile(path string, ok bool) {}
+
+func (r *JSONReporter) Summary(summary Summary) {
+	if r.ndjson {
+		r.writeLine(summary)
+		return
+	}
+
+	files := r.entries
+	if files == nil {
+		files = []FileReport{}
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Files   []FileReport `json:"files"`
+		Summary Summary      `json:"summary"`
+	}{files, summary}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(r.w, `{"error": %q}`+"\n", err)
+		return
+	}
+
+	r.w.Write(data)
+	r.w.Write([]byte("\n"))
+}
+
+func (r *JSONReporter) writeLine(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(r.w, `{"error": %q}`+"\n", err)
+		return
+	}
+
+	r.w.Write(data)
+	r.w.Write([]byte("\n"))
+}
+
+// newReporter builds the Reporter selected by the global --output flag,
+// directing it at the global --report file if one was given, or at
+// stdout otherwise. The returned close func must be deferred by the
+// caller; it's a no-op unless --report opened a file.
+func newReporter(ctx *cli.Context) (Reporter, func() error, error) {
+	var w io.Writer = os.Stdout
+	closeFn := func() error { return nil }
+
+	if reportPath := ctx.GlobalString("report"); reportPath != "" {
+		f, err := os.Create(reportPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w = f
+		closeFn = f.Close
+	}
+
+	switch ctx.GlobalString("output") {
+	case "text", "":
+		reportWriter := w
+		if reportWriter == io.Writer(os.Stdout) {
+			reportWriter = nil
+		}
+
+		return &TextReporter{w: reportWriter}, closeFn, nil
+	case "json":
+		return &JSONReporter{w: w}, closeFn, nil
+	case "ndjson":
+		return &JSONReporter{w: w, ndjson: true}, closeFn, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown --output format: %v", ctx.GlobalString("output"))
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+	"github.com/fatih/color"
+)
+
+// treeManifestEntry is one line of a treehash --manifest listing: the
+// digest of a single file or directory, and its path relative to the
+// tree root (directories are suffixed with "/").
+type treeManifestEntry struct {
+	Digest string
+	Path   string
+}
+
+func relJoin(base, name string) string {
+	if base == "" {
+		return name
+	}
+
+	return base + "/" + name
+}
+
+func hashBytes(data []byte, algo string) (string, error) {
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+
+	hasher.Write(data)
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// treeHashDir computes root's digest as a Merkle tree: every regular
+// file's digest is its content hash, and every directory's digest is the
+// hash of its sorted entries' "name || mode || digest" records, so a
+// directory's digest depends on everything beneath it. relPath is root's
+// path relative to the tree root ("" for the root itself) and is used
+// both to locate the directory on disk and to label manifest entries.
+// Every visited file and directory is appended to *manifest.
+func treeHashDir(root string, relPath string, algo string, symlinkMode string, manifest *[]treeManifestEntry) (string, error) {
+	dirPath := root
+	if relPath != "" {
+		dirPath = filepath.Join(root, filepath.FromSlash(relPath))
+	}
+
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var record bytes.Buffer
+	for _, entry := range entries {
+		entryRel := relJoin(relPath, entry.Name())
+		entryPath := filepath.Join(root, filepath.FromSlash(entryRel))
+
+		var digest, modeTag string
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			switch symlinkMode {
+			case "ignore":
+				continue
+			case "hash-target":
+				target, err := os.Readlink(entryPath)
+				if err != nil {
+					return "", err
+				}
+
+				if digest, err = hashBytes([]byte(target), algo); err != nil {
+					return "", err
+				}
+
+				modeTag = "l"
+				*manifest = append(*manifest, treeManifestEntry{Digest: digest, Path: entryRel})
+			case "follow":
+				targetInfo, err := os.Stat(entryPath)
+				if err != nil {
+					return "", err
+				}
+
+				if targetInfo.IsDir() {
+					if digest, err = treeHashDir(root, entryRel, algo, symlinkMode, manifest); err != nil {
+						return "", err
+					}
+
+					modeTag = fmt.Sprintf("d%04o", targetInfo.Mode().Perm())
+				} else {
+					if digest, _, err = hashFile(entryPath, algo); err != nil {
+						return "", err
+					}
+
+					modeTag = fmt.Sprintf("f%04o", targetInfo.Mode().Perm())
+					*manifest = append(*manifest, treeManifestEntry{Digest: digest, Path: entryRel})
+				}
+			default:
+				return "", fmt.Errorf("unknown --symlinks mode: %v", symlinkMode)
+			}
+		} else if entry.IsDir() {
+			if digest, err = treeHashDir(root, entryRel, algo, symlinkMode, manifest); err != nil {
+				return "", err
+			}
+
+			modeTag = fmt.Sprintf("d%04o", entry.Mode().Perm())
+		} else {
+			if digest, _, err = hashFile(entryPath, algo); err != nil {
+				return "", err
+			}
+
+			modeTag = fmt.Sprintf("f%04o", entry.Mode().Perm())
+			*manifest = append(*manifest, treeManifestEntry{Digest: digest, Path: entryRel})
+		}
+
+		record.WriteString(entry.Name())
+		record.WriteString(modeTag)
+		record.WriteString(digest)
+		record.WriteString("\n")
+	}
+
+	// Directories with no (surviving) entries still participate in the
+	// digest, as the hash of an empty record.
+	dirDigest, err := hashBytes(record.Bytes(), algo)
+	if err != nil {
+		return "", err
+	}
+
+	manifestPath := relPath
+	if manifestPath == "" {
+		manifestPath = "."
+	}
+	*manifest = append(*manifest, treeManifestEntry{Digest: dirDigest, Path: manifestPath + "/"})
+
+	return dirDigest, nil
+}
+
+func treehashCommand(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		fmt.Println("Incorrect Usage.\n")
+		cli.ShowSubcommandHelp(ctx)
+		return nil
+	}
+
+	root := ctx.Args()[0]
+	algo := ctx.GlobalString("algo")
+	symlinks := ctx.String("symlinks")
+
+	var manifest []treeManifestEntry
+	digest, err := treeHashDir(root, "", algo, symlinks, &manifest)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	fmt.Println(digest)
+
+	if ctx.Bool("manifest") {
+		sort.Slice(manifest, func(i, j int) bool {
+			return manifest[i].Path < manifest[j].Path
+		})
+
+		for _, entry := range manifest {
+			fmt.Printf("%v %v\n", entry.Digest, entry.Path)
+		}
+	}
+
+	return nil
+}
+
+// readTreeReference loads what a treehash digest should be compared
+// against: either the path to a treehash/treehash --manifest output (the
+// first line is the root digest, any further "<digest> <path>" lines
+// become the manifest to diff against), or, if ref isn't an existing
+// file, the literal expected digest.
+func readTreeReference(ref string) (string, map[string]string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return strings.ToLower(strings.TrimSpace(ref)), nil, nil
+		}
+
+		return "", nil, err
+	}
+
+	var digest string
+	manifest := make(map[string]string)
+
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if digest == "" {
+			digest = strings.ToLower(fields[0])
+		}
+
+		if len(fields) == 2 {
+			manifest[fields[1]] = strings.ToLower(fields[0])
+		}
+	}
+
+	return digest, manifest, nil
+}
+
+func treeverifyCommand(ctx *cli.Context) error {
+	if len(ctx.Args()) < 2 {
+		fmt.Println("Incorrect Usage.\n")
+		cli.ShowSubcommandHelp(ctx)
+		return nil
+	}
+
+	root, ref := ctx.Args()[0], ctx.Args()[1]
+	algo := ctx.GlobalString("algo")
+	symlinks := ctx.String("symlinks")
+	fmt.Print(sprintfHeader("Verifying tree: %v", root))
+
+	expectedDigest, expectedManifest, err := readTreeReference(ref)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	var manifest []treeManifestEntry
+	digest, err := treeHashDir(root, "", algo, symlinks, &manifest)
+	if err != nil {
+		printColored(fmt.Sprintf(" Error: %v\n\n", err), color.FgRed)
+		os.Exit(1)
+	}
+
+	if digest == expectedDigest {
+		printColored(fmt.Sprintf(" OK: %v\n\n", root), color.FgGreen)
+		return nil
+	}
+
+	printColored(fmt.Sprintf(" Mismatch: %v\n", root), color.FgRed)
+	printColored(fmt.Sprintf("   expected %v\n   actual   %v\n", expectedDigest, digest), color.FgRed)
+
+	if len(expectedManifest) > 0 {
+		actual := make(map[string]string, len(manifest))
+		for _, entry := range manifest {
+			actual[entry.Path] = entry.Digest
+		}
+
+		for path, expected := range expectedManifest {
+			if got, ok := actual[path]; !ok {
+				printColored(fmt.Sprintf("   Missing: %v\n", path), color.FgRed)
+			} else if got != expected {
+				printColored(fmt.Sprintf("   Changed: %v\n", path), color.FgRed)
+			}
+		}
+
+		for path := range actual {
+			if _, ok := expectedManifest[path]; !ok {
+				printColored(fmt.Sprintf("   Added: %v\n", path), color.FgRed)
+			}
+		}
+	}
+
+	fmt.Print("\n")
+	os.Exit(1)
+	return nil
+}